@@ -1,20 +1,20 @@
 package mwclient
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
-	"net/http/httputil"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
 
 	"cgt.name/pkg/go-mwclient/params"
 
 	"github.com/antonholmquist/jason"
+	"golang.org/x/time/rate"
 )
 
 // If you modify this package, please change the user agent.
@@ -45,7 +45,29 @@ type (
 		// the value 'user' or 'bot', respectively. To disable such assertions,
 		// set Assert to AssertNone (set by default by New()).
 		Assert assertType
-		debug  io.Writer
+		// RateLimit, if non-nil, is waited on before every API request is
+		// dispatched, pacing requests made by this Client (and any goroutines
+		// sharing it) to the configured rate. A nil RateLimit (the default)
+		// means requests are not paced. Use SetRateLimit to configure it.
+		RateLimit *rate.Limiter
+		// RetryPolicy, if non-nil, is consulted by the retry middleware (see
+		// Use) to retry network errors and retryable HTTP statuses, backing
+		// off between attempts. A nil RetryPolicy (the default) disables
+		// this layer; maxlag retrying (via Maxlag) is unaffected either way.
+		// See the RetryPolicy and DefaultRetryPolicy docs for details.
+		RetryPolicy *RetryPolicy
+		// oauth holds the OAuth 1.0a credentials set by NewOAuth1. A nil
+		// oauth means Client authenticates the legacy way, via Login and
+		// the cookie jar.
+		oauth *oauth1Credentials
+		debug io.Writer
+		// baseTransport is the innermost layer of the Transport middleware
+		// chain -- the one that actually talks to the network. Set via
+		// SetTransport; nil means http.DefaultTransport.
+		baseTransport http.RoundTripper
+		// middlewares are applied around baseTransport, in the order
+		// passed to Use, to build w.httpc.Transport. See Use.
+		middlewares []func(http.RoundTripper) http.RoundTripper
 	}
 
 	// Maxlag contains maxlag configuration for Client.
@@ -77,6 +99,14 @@ type (
 // received. To disable, set to nil (default).
 func (w *Client) SetDebug(wr io.Writer) { w.debug = wr }
 
+// SetRateLimit configures Client to pace outgoing API requests to at most
+// rps requests per second, allowing bursts of up to burst requests. A rps
+// of math.Inf(1) lets every request through unpaced (burst is then
+// ignored). To disable pacing, assign Client.RateLimit = nil directly.
+func (w *Client) SetRateLimit(rps float64, burst int) {
+	w.RateLimit = rate.NewLimiter(rate.Limit(rps), burst)
+}
+
 type sleeper func(d time.Duration)
 
 // New returns a pointer to an initialized Client object. If the provided API URL
@@ -104,7 +134,7 @@ func New(inURL, userAgent string) (*Client, error) {
 		return nil, fmt.Errorf("userAgent parameter empty")
 	}
 
-	return &Client{
+	w := &Client{
 		httpc: &http.Client{
 			Transport:     nil,
 			CheckRedirect: nil,
@@ -120,131 +150,124 @@ func New(inURL, userAgent string) (*Client, error) {
 			sleep:   time.Sleep,
 		},
 		Assert: AssertNone,
-	}, nil
+	}
+
+	// Build the default middleware chain, innermost first: debug dumping,
+	// then OAuth signing, then maxlag retrying, then RetryPolicy retrying,
+	// then rate limiting (outermost, so it gates only the initial send of
+	// a call, not its retries). Debug dumping sits innermost, with OAuth
+	// signing wrapped directly around it, so debugMiddleware sees (and
+	// dumps) the request exactly as oauthMiddleware left it -- signed
+	// Authorization header included -- for every attempt, rather than a
+	// pre-signing stand-in dumped once per logical call. See Use for why
+	// order matters and how to change it.
+	w.Use(w.debugMiddleware)
+	w.Use(w.oauthMiddleware)
+	w.Use(w.maxlagMiddleware)
+	w.Use(w.retryMiddleware)
+	w.Use(w.rateLimitMiddleware)
+
+	return w, nil
 }
 
 // call makes a GET or POST request to the Mediawiki API depending on whether
 // the post argument is true or false (if true, it will POST) and returns
 // the response body as an io.ReadCloser. Remember to close it when done with it.
-// call supports the maxlag parameter and will respect it if it is turned on
-// in the Client it operates on.
-func (w *Client) call(p params.Values, post bool) (io.ReadCloser, error) {
-	// The main functionality in this method is in a closure to simplify maxlag handling.
-	callf := func() (io.ReadCloser, error) {
-		p.Set("format", "json")
-		p.Set("utf8", "")
-
-		if w.Maxlag.On {
-			if p.Get("maxlag") == "" {
-				// User has not set maxlag param manually. Use configured value.
-				p.Set("maxlag", w.Maxlag.Timeout)
-			}
-		}
-
-		if w.Assert > AssertNone {
-			switch w.Assert {
-			case AssertUser:
-				p.Set("assert", "user")
-			case AssertBot:
-				p.Set("assert", "bot")
-			}
-		}
-
-		// Make a POST or GET request depending on the "post" parameter.
-		var httpMethod string
-		if post {
-			httpMethod = "POST"
-		} else {
-			httpMethod = "GET"
-		}
-
-		var req *http.Request
-		var err error
-		if post {
-			req, err = http.NewRequest(httpMethod, w.apiURL.String(), strings.NewReader(p.Encode()))
-		} else {
-			req, err = http.NewRequest(httpMethod, fmt.Sprintf("%s?%s", w.apiURL.String(), p.Encode()), nil)
-		}
-		if err != nil {
-			return nil, fmt.Errorf("unable to create HTTP request (method: %s, params: %v): %v",
-				httpMethod, p, err)
-		}
+// call honors ctx: the underlying HTTP request is created with
+// http.NewRequestWithContext, which lets the middleware chain (see Use)
+// cancel or abort retries when ctx is done.
+// call itself makes a single request; debug dumping, rate limiting, maxlag
+// retrying, and RetryPolicy retrying are all handled transparently by the
+// middleware chain wrapped around w.httpc's Transport.
+func (w *Client) call(ctx context.Context, p params.Values, post bool) (io.ReadCloser, error) {
+	resp, err := w.doRequest(ctx, p, post)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
 
-		// Set headers on request
-		req.Header.Set("User-Agent", w.UserAgent)
-		if post {
-			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		}
+// doRequest builds and sends a single HTTP request for p, applying the
+// maxlag and assert parameters. OAuth signing, debug dumping, rate
+// limiting, and maxlag/RetryPolicy retrying are all handled by the
+// middleware chain wrapped around w.httpc's Transport (see middleware.go),
+// so a single call here may correspond to several HTTP round trips. It
+// does not interpret the response in any way; callers are responsible for
+// that and for closing resp.Body.
+func (w *Client) doRequest(ctx context.Context, p params.Values, post bool) (*http.Response, error) {
+	p.Set("format", "json")
+	p.Set("utf8", "")
 
-		if w.debug != nil {
-			reqdump, err := httputil.DumpRequestOut(req, true)
-			if err != nil {
-				w.debug.Write([]byte(fmt.Sprintf("Err dumping request: %v\n", err)))
-			} else {
-				w.debug.Write(reqdump)
-			}
+	if w.Maxlag.On {
+		if p.Get("maxlag") == "" {
+			// User has not set maxlag param manually. Use configured value.
+			p.Set("maxlag", w.Maxlag.Timeout)
 		}
+	}
 
-		// Make the request
-		resp, err := w.httpc.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("error occured during HTTP request: %v", err)
+	if w.Assert > AssertNone {
+		switch w.Assert {
+		case AssertUser:
+			p.Set("assert", "user")
+		case AssertBot:
+			p.Set("assert", "bot")
 		}
+	}
 
-		if w.debug != nil {
-			respdump, err := httputil.DumpResponse(resp, true)
-			if err != nil {
-				w.debug.Write([]byte(fmt.Sprintf("Err dumping response: %v\n", err)))
-			} else {
-				w.debug.Write(respdump)
-			}
-		}
+	// Make a POST or GET request depending on the "post" parameter.
+	var httpMethod string
+	if post {
+		httpMethod = "POST"
+	} else {
+		httpMethod = "GET"
+	}
 
-		// Handle maxlag
-		if resp.Header.Get("X-Database-Lag") != "" {
-			defer resp.Body.Close()
-			retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
-			if err != nil {
-				return nil, err
-			}
-
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				return nil, err
-			}
-
-			return nil, maxLagError{
-				string(body),
-				retryAfter,
-			}
-		}
+	var req *http.Request
+	var err error
+	if post {
+		req, err = http.NewRequestWithContext(ctx, httpMethod, w.apiURL.String(), strings.NewReader(p.Encode()))
+	} else {
+		req, err = http.NewRequestWithContext(ctx, httpMethod, fmt.Sprintf("%s?%s", w.apiURL.String(), p.Encode()), nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create HTTP request (method: %s, params: %v): %v",
+			httpMethod, p, err)
+	}
 
-		return resp.Body, nil
+	// Set headers on request
+	req.Header.Set("User-Agent", w.UserAgent)
+	if post {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
 
+	// Make the request. w.httpc.Transport is the middleware chain built by
+	// Use/SetTransport; it handles OAuth signing, debug dumping, rate
+	// limiting, and maxlag/RetryPolicy retrying, so a single Do call here
+	// may correspond to several HTTP round trips, each freshly signed.
+	resp, err := w.httpc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error occured during HTTP request: %w", err)
 	}
 
-	if w.Maxlag.On {
-		for tries := 0; tries < w.Maxlag.Retries; tries++ {
-			reqResp, err := callf()
-
-			// Logic for handling maxlag errors. If err is nil or a different error,
-			// they are passed through in the else.
-			if lagerr, ok := err.(maxLagError); ok {
-				// If there are no tries left, don't wait needlessly.
-				if tries < w.Maxlag.Retries-1 {
-					w.Maxlag.sleep(time.Duration(lagerr.Wait) * time.Second)
-				}
-				continue
-			} else {
-				return reqResp, err
-			}
-		}
+	return resp, nil
+}
 
-		return nil, ErrAPIBusy
+// maxlagSleep waits for d using w.Maxlag.sleep (which tests may replace with
+// a mock to avoid prolonging test execution), but returns early with
+// ctx.Err() if ctx is cancelled first.
+func (w *Client) maxlagSleep(ctx context.Context, d time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		w.Maxlag.sleep(d)
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
 	}
-
-	// If maxlag is not enabled, just do the request regularly.
-	return callf()
 }
 
 // callJSON wraps the call method and encodes the JSON response
@@ -252,8 +275,14 @@ func (w *Client) call(p params.Values, post bool) (io.ReadCloser, error) {
 // extracted and returned as the error return value (unless an error occurs
 // during the API call or the parsing of the JSON response, in which case that
 // error will be returned and the *jason.Object return value will be nil).
-func (w *Client) callJSON(p params.Values, post bool) (*jason.Object, error) {
-	body, err := w.call(p, post)
+func (w *Client) callJSON(ctx context.Context, p params.Values, post bool) (*jason.Object, error) {
+	return w.parseJSON(w.call(ctx, p, post))
+}
+
+// parseJSON is the tail shared by callJSON and callMultipart: it closes
+// body (if non-nil), decodes it as a *jason.Object, and extracts any API
+// errors/warnings into the error return value.
+func (w *Client) parseJSON(body io.ReadCloser, err error) (*jason.Object, error) {
 	if err != nil {
 		return nil, err
 	}
@@ -270,8 +299,8 @@ func (w *Client) callJSON(p params.Values, post bool) (*jason.Object, error) {
 }
 
 // callRaw wraps the call method and reads the response body into a []byte.
-func (w *Client) callRaw(p params.Values, post bool) ([]byte, error) {
-	body, err := w.call(p, post)
+func (w *Client) callRaw(ctx context.Context, p params.Values, post bool) ([]byte, error) {
+	body, err := w.call(ctx, p, post)
 	if err != nil {
 		return nil, err
 	}
@@ -292,7 +321,13 @@ func (w *Client) callRaw(p params.Values, post bool) ([]byte, error) {
 // Get will return any API errors and/or warnings (if no other errors occur)
 // as the error return value.
 func (w *Client) Get(p params.Values) (*jason.Object, error) {
-	return w.callJSON(p, false)
+	return w.GetCtx(context.Background(), p)
+}
+
+// GetCtx is like Get, but it threads ctx through to the underlying HTTP
+// request so that it can be cancelled or given a deadline by the caller.
+func (w *Client) GetCtx(ctx context.Context, p params.Values) (*jason.Object, error) {
+	return w.callJSON(ctx, p, false)
 }
 
 // GetRaw performs a GET request with the specified parameters
@@ -301,7 +336,13 @@ func (w *Client) Get(p params.Values) (*jason.Object, error) {
 // GetRaw is useful when you want to decode the JSON into a struct for easier
 // and safer use.
 func (w *Client) GetRaw(p params.Values) ([]byte, error) {
-	return w.callRaw(p, false)
+	return w.GetRawCtx(context.Background(), p)
+}
+
+// GetRawCtx is like GetRaw, but it threads ctx through to the underlying
+// HTTP request so that it can be cancelled or given a deadline by the caller.
+func (w *Client) GetRawCtx(ctx context.Context, p params.Values) ([]byte, error) {
+	return w.callRaw(ctx, p, false)
 }
 
 // Post performs a POST request with the specified parameters and returns the
@@ -309,7 +350,13 @@ func (w *Client) GetRaw(p params.Values) ([]byte, error) {
 // Post will return any API errors and/or warnings (if no other errors occur)
 // as the error return value.
 func (w *Client) Post(p params.Values) (*jason.Object, error) {
-	return w.callJSON(p, true)
+	return w.PostCtx(context.Background(), p)
+}
+
+// PostCtx is like Post, but it threads ctx through to the underlying HTTP
+// request so that it can be cancelled or given a deadline by the caller.
+func (w *Client) PostCtx(ctx context.Context, p params.Values) (*jason.Object, error) {
+	return w.callJSON(ctx, p, true)
 }
 
 // PostRaw performs a POST request with the specified parameters
@@ -318,13 +365,25 @@ func (w *Client) Post(p params.Values) (*jason.Object, error) {
 // PostRaw is useful when you want to decode the JSON into a struct for easier
 // and safer use.
 func (w *Client) PostRaw(p params.Values) ([]byte, error) {
-	return w.callRaw(p, true)
+	return w.PostRawCtx(context.Background(), p)
+}
+
+// PostRawCtx is like PostRaw, but it threads ctx through to the underlying
+// HTTP request so that it can be cancelled or given a deadline by the caller.
+func (w *Client) PostRawCtx(ctx context.Context, p params.Values) ([]byte, error) {
+	return w.callRaw(ctx, p, true)
 }
 
 // Login attempts to login using the provided username and password.
 // Login sets Client.Assert to AssertUser if login is successful.
 func (w *Client) Login(username, password string) error {
-	token, err := w.GetToken(LoginToken)
+	return w.LoginCtx(context.Background(), username, password)
+}
+
+// LoginCtx is like Login, but it threads ctx through to the underlying HTTP
+// request so that it can be cancelled or given a deadline by the caller.
+func (w *Client) LoginCtx(ctx context.Context, username, password string) error {
+	token, err := w.GetTokenCtx(ctx, LoginToken)
 	if err != nil {
 		return err
 	}
@@ -334,7 +393,7 @@ func (w *Client) Login(username, password string) error {
 		"lgpassword": password,
 		"lgtoken":    token,
 	}
-	resp, err := w.Post(v)
+	resp, err := w.PostCtx(ctx, v)
 	if err != nil {
 		return err
 	}
@@ -355,6 +414,12 @@ func (w *Client) Login(username, password string) error {
 // Logout does not take into account whether or not a user is actually logged in.
 // Logout sets Client.Assert to AssertNone.
 func (w *Client) Logout() {
+	w.LogoutCtx(context.Background())
+}
+
+// LogoutCtx is like Logout, but it threads ctx through to the underlying
+// HTTP request so that it can be cancelled or given a deadline by the caller.
+func (w *Client) LogoutCtx(ctx context.Context) {
 	w.Assert = AssertNone
-	w.Get(params.Values{"action": "logout"})
+	w.GetCtx(ctx, params.Values{"action": "logout"})
 }
@@ -0,0 +1,43 @@
+package mwclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestMaxlagSleepCtxCancellation checks that maxlagSleep returns ctx.Err()
+// as soon as ctx is cancelled, rather than waiting out the full sleep
+// duration -- the behavior request #1 added context.Context support for.
+func TestMaxlagSleepCtxCancellation(t *testing.T) {
+	w := &Client{Maxlag: Maxlag{sleep: func(time.Duration) { time.Sleep(time.Hour) }}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	err := w.maxlagSleep(ctx, time.Hour)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("maxlagSleep error = %v, want context.Canceled", err)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("maxlagSleep took %v, want it to return promptly on ctx cancellation", elapsed)
+	}
+}
+
+// TestMaxlagSleepCompletes checks that maxlagSleep returns nil once the
+// configured sleep func returns, when ctx is never cancelled.
+func TestMaxlagSleepCompletes(t *testing.T) {
+	var slept time.Duration
+	w := &Client{Maxlag: Maxlag{sleep: func(d time.Duration) { slept = d }}}
+
+	if err := w.maxlagSleep(context.Background(), 5*time.Second); err != nil {
+		t.Fatalf("maxlagSleep: %v", err)
+	}
+	if slept != 5*time.Second {
+		t.Fatalf("maxlagSleep called sleep with %v, want 5s", slept)
+	}
+}
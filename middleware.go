@@ -0,0 +1,228 @@
+package mwclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"time"
+)
+
+// RoundTripperFunc adapts a function to an http.RoundTripper, mirroring the
+// standard library's http.HandlerFunc.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// Use appends mw to Client's middleware chain and rebuilds the Transport
+// used to send requests. Middlewares wrap the transport in the order they
+// are passed to Use: the first call to Use ends up innermost (closest to
+// the network), and each subsequent call wraps around the previous ones,
+// ending up outermost (seeing the request first and the response last).
+//
+// New wires up, innermost to outermost: a debug-dumping layer, an
+// OAuth-signing layer, a maxlag-retrying layer, a RetryPolicy-retrying
+// layer, and a rate-limiting layer. Debug dumping sits innermost with
+// OAuth signing wrapped directly around it, so it dumps the request
+// exactly as it is sent over the wire -- signed Authorization header
+// included -- for every attempt, rather than a pre-signing stand-in
+// dumped once per logical call. Rate limiting sits outermost so it gates
+// only the initial send of a call rather than every retry. Call Use to
+// add your own layers (metrics, tracing, caching, etc.) on top of that
+// chain.
+func (w *Client) Use(mw func(http.RoundTripper) http.RoundTripper) {
+	w.middlewares = append(w.middlewares, mw)
+	w.rebuildTransport()
+}
+
+// SetTransport replaces the innermost layer of Client's Transport chain --
+// the one that actually performs the network round trip -- with base. This
+// is the layer tests should replace with one pointed at an
+// httptest.Server. Passing nil restores the default of http.DefaultTransport.
+func (w *Client) SetTransport(base http.RoundTripper) {
+	w.baseTransport = base
+	w.rebuildTransport()
+}
+
+// rebuildTransport recomputes w.httpc.Transport by wrapping w.baseTransport
+// with every middleware in w.middlewares, in order.
+func (w *Client) rebuildTransport() {
+	var rt http.RoundTripper = w.baseTransport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	for _, mw := range w.middlewares {
+		rt = mw(rt)
+	}
+	w.httpc.Transport = rt
+}
+
+// cloneRequestForRetry returns a copy of req suitable for a retried attempt:
+// a shallow clone with its body re-obtained from GetBody, since req's
+// original Body has already been consumed by the previous attempt.
+func cloneRequestForRetry(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("unable to rewind request body for retry: %v", err)
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
+
+// debugMiddleware dumps each request and response it sees to w.debug (if
+// set with SetDebug) with httputil, mirroring what call used to do inline.
+// It is wired directly inside oauthMiddleware (see New), so what it dumps
+// is the request as actually sent -- including the signed Authorization
+// header in OAuth mode -- for every attempt, not just the first.
+func (w *Client) debugMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if w.debug != nil {
+			if reqdump, err := httputil.DumpRequestOut(req, true); err != nil {
+				w.debug.Write([]byte(fmt.Sprintf("Err dumping request: %v\n", err)))
+			} else {
+				w.debug.Write(reqdump)
+			}
+		}
+
+		resp, err := next.RoundTrip(req)
+
+		if w.debug != nil && resp != nil {
+			if respdump, derr := httputil.DumpResponse(resp, true); derr != nil {
+				w.debug.Write([]byte(fmt.Sprintf("Err dumping response: %v\n", derr)))
+			} else {
+				w.debug.Write(respdump)
+			}
+		}
+
+		return resp, err
+	})
+}
+
+// rateLimitMiddleware waits on w.RateLimit (if set with SetRateLimit)
+// before letting a request through, pacing requests made by this Client
+// (and any goroutines sharing it) to the configured rate.
+func (w *Client) rateLimitMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if w.RateLimit != nil {
+			if err := w.RateLimit.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+		return next.RoundTrip(req)
+	})
+}
+
+// maxlagMiddleware retries a request, up to w.Maxlag.Retries times, for as
+// long as the response carries an X-Database-Lag header while w.Maxlag.On
+// is true, sleeping for the Retry-After duration between attempts (via
+// maxlagSleep, so tests can mock the sleep). If the retries are exhausted
+// while still lagged, it gives up with ErrAPIBusy.
+func (w *Client) maxlagMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !w.Maxlag.On {
+			return next.RoundTrip(req)
+		}
+
+		for tries := 0; tries < w.Maxlag.Retries; tries++ {
+			if err := req.Context().Err(); err != nil {
+				return nil, err
+			}
+
+			attempt, err := cloneRequestForRetry(req)
+			if err != nil {
+				return nil, err
+			}
+
+			resp, err := next.RoundTrip(attempt)
+			if err != nil {
+				return nil, err
+			}
+
+			if resp.Header.Get("X-Database-Lag") == "" {
+				return resp, nil
+			}
+
+			retryAfter, convErr := strconv.Atoi(resp.Header.Get("Retry-After"))
+			resp.Body.Close()
+			if convErr != nil {
+				return nil, convErr
+			}
+
+			if tries < w.Maxlag.Retries-1 {
+				if err := w.maxlagSleep(req.Context(), time.Duration(retryAfter)*time.Second); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return nil, ErrAPIBusy
+	})
+}
+
+// retryMiddleware consults w.RetryPolicy (if set) to decide whether to
+// retry a request, backing off between attempts as the policy directs.
+// With no RetryPolicy configured, it is a pass-through.
+func (w *Client) retryMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		policy := w.RetryPolicy
+		if policy == nil {
+			return next.RoundTrip(req)
+		}
+
+		checkRetry := policy.CheckRetry
+		if checkRetry == nil {
+			checkRetry = DefaultCheckRetry
+		}
+		backoff := policy.Backoff
+		if backoff == nil {
+			backoff = DefaultBackoff
+		}
+
+		for attempt := 0; ; attempt++ {
+			if err := req.Context().Err(); err != nil {
+				return nil, err
+			}
+
+			reqAttempt, err := cloneRequestForRetry(req)
+			if err != nil {
+				return nil, err
+			}
+
+			resp, doErr := next.RoundTrip(reqAttempt)
+
+			retry, checkErr := checkRetry(resp, doErr)
+			if checkErr != nil {
+				if resp != nil {
+					resp.Body.Close()
+				}
+				return nil, checkErr
+			}
+			if !retry {
+				return resp, doErr
+			}
+
+			if attempt >= policy.MaxRetries {
+				if resp != nil {
+					defer resp.Body.Close()
+					return nil, fmt.Errorf("giving up after %d attempts, last HTTP status: %s", attempt+1, resp.Status)
+				}
+				return nil, fmt.Errorf("giving up after %d attempts: %v", attempt+1, doErr)
+			}
+
+			delay := backoff(policy.MinDelay, policy.MaxDelay, attempt, resp)
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(delay):
+			}
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package mwclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cgt.name/pkg/go-mwclient/params"
+)
+
+// TestMaxlagRetryPolicyShareOneBudget verifies the fix for the bug where
+// retryMiddleware treated a maxlagMiddleware-exhausted ErrAPIBusy as a
+// generic retryable error and re-ran the whole maxlag loop again,
+// multiplying real HTTP requests and losing the ErrAPIBusy error type. With
+// both Maxlag and RetryPolicy configured against a server that always
+// reports lag, maxlag alone should own the retry budget: exactly
+// Maxlag.Retries requests should be made, and the final error should be
+// ErrAPIBusy, not a generic "giving up after N attempts" error.
+func TestMaxlagRetryPolicyShareOneBudget(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(wtr http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		wtr.Header().Set("X-Database-Lag", "5")
+		wtr.Header().Set("Retry-After", "0")
+		wtr.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	w, err := New(srv.URL, "test")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	w.Maxlag.On = true
+	w.Maxlag.Retries = 2
+	w.Maxlag.sleep = func(time.Duration) {}
+	w.RetryPolicy = DefaultRetryPolicy(2, 0, 0)
+
+	_, err = w.call(context.Background(), params.Values{}, false)
+	if !errors.Is(err, ErrAPIBusy) {
+		t.Fatalf("call error = %v, want ErrAPIBusy", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != int32(w.Maxlag.Retries) {
+		t.Fatalf("server received %d requests, want %d", got, w.Maxlag.Retries)
+	}
+}
+
+// TestDebugMiddlewareDumpsSignedRequest is a regression test for the bug
+// where debugMiddleware was wired outside oauthMiddleware, so SetDebug
+// dumped the pre-signing request object instead of the one actually sent
+// over the wire: the server would receive a signed Authorization header
+// that never showed up in the debug dump. debugMiddleware must sit
+// directly inside oauthMiddleware so the dump reflects what was sent.
+func TestDebugMiddlewareDumpsSignedRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(wtr http.ResponseWriter, r *http.Request) {
+		wtr.Header().Set("Content-Type", "application/json")
+		wtr.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	w := NewOAuth1(srv.URL, "test", OAuthConsumer{Key: "ck", Secret: "cs"}, OAuthToken{Key: "tk", Secret: "ts"})
+
+	var buf bytes.Buffer
+	w.SetDebug(&buf)
+
+	if _, err := w.GetCtx(context.Background(), params.Values{"action": "query"}); err != nil {
+		t.Fatalf("GetCtx: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "Authorization: OAuth") {
+		t.Fatalf("debug dump does not contain the signed Authorization header:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,62 @@
+package mwclient
+
+import (
+	"net/url"
+	"testing"
+)
+
+// TestOAuthSignWith checks oauthSignWith against a fixed request, consumer,
+// token, nonce, and timestamp, pinning the resulting Authorization header
+// so a future change to param selection, key ordering, or percent-encoding
+// is caught even though it would otherwise still produce a validly
+// formatted (if wrong) header.
+func TestOAuthSignWith(t *testing.T) {
+	w := &Client{oauth: &oauth1Credentials{
+		consumer: OAuthConsumer{Key: "ck-key", Secret: "ck-secret"},
+		token:    OAuthToken{Key: "tok-key", Secret: "tok-secret"},
+	}}
+
+	u, err := url.Parse("https://example.org/w/api.php?action=query&format=json")
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	form := url.Values{"titles": {"Main Page"}}
+
+	got, err := w.oauthSignWith("POST", u, form, "fixednonce123", "1700000000")
+	if err != nil {
+		t.Fatalf("oauthSignWith: %v", err)
+	}
+
+	const want = `OAuth oauth_consumer_key="ck-key", oauth_nonce="fixednonce123", ` +
+		`oauth_signature="G3NzXso5%2F%2BW7k3kwMLR25njPhuQ%3D", ` +
+		`oauth_signature_method="HMAC-SHA1", oauth_timestamp="1700000000", ` +
+		`oauth_token="tok-key", oauth_version="1.0"`
+	if got != want {
+		t.Fatalf("oauthSignWith =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestOAuthEncodeParams(t *testing.T) {
+	got := oauthEncodeParams(url.Values{
+		"b": {"two words"},
+		"a": {"1"},
+	})
+	const want = "a=1&b=two%20words"
+	if got != want {
+		t.Fatalf("oauthEncodeParams = %q, want %q", got, want)
+	}
+}
+
+func TestOAuthPercentEncode(t *testing.T) {
+	cases := map[string]string{
+		"abcXYZ019-._~": "abcXYZ019-._~",
+		"a b":           "a%20b",
+		"a/b":           "a%2Fb",
+		"a+b":           "a%2Bb",
+	}
+	for in, want := range cases {
+		if got := oauthPercentEncode(in); got != want {
+			t.Errorf("oauthPercentEncode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
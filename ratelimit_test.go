@@ -0,0 +1,75 @@
+package mwclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cgt.name/pkg/go-mwclient/params"
+	"golang.org/x/time/rate"
+)
+
+// TestSetRateLimit checks that SetRateLimit wires Client.RateLimit to a
+// *rate.Limiter configured with exactly the rps and burst given.
+func TestSetRateLimit(t *testing.T) {
+	w := &Client{}
+	w.SetRateLimit(2, 5)
+
+	if w.RateLimit == nil {
+		t.Fatal("SetRateLimit left RateLimit nil")
+	}
+	if got := w.RateLimit.Limit(); got != rate.Limit(2) {
+		t.Errorf("RateLimit.Limit() = %v, want 2", got)
+	}
+	if got := w.RateLimit.Burst(); got != 5 {
+		t.Errorf("RateLimit.Burst() = %v, want 5", got)
+	}
+}
+
+// TestRateLimitMiddlewareHonorsCtxCancellation checks that
+// rateLimitMiddleware actually waits on Client.RateLimit before letting a
+// request through, proving the middleware is in the request path rather
+// than a RateLimit field nobody reads. A limiter with a burst of 1 allows
+// one request through immediately; a second request, with the single
+// token gone and the next one an hour away, must be cut short by ctx's
+// deadline instead of actually waiting an hour, and must never reach the
+// server.
+func TestRateLimitMiddlewareHonorsCtxCancellation(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(wtr http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		wtr.Header().Set("Content-Type", "application/json")
+		wtr.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	w, err := New(srv.URL, "test")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	w.RateLimit = rate.NewLimiter(rate.Every(time.Hour), 1)
+
+	if _, err := w.GetCtx(context.Background(), params.Values{"action": "query"}); err != nil {
+		t.Fatalf("first GetCtx (consuming the only token): %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = w.GetCtx(ctx, params.Values{"action": "query"})
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("second GetCtx: got nil error, want one reporting the wait can't meet ctx's deadline")
+	}
+	if elapsed > 5*time.Second {
+		t.Fatalf("second GetCtx took %v, want it cut short by ctx's deadline rather than waiting an hour", elapsed)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Fatalf("server received %d requests, want 1 (the second should have been blocked)", got)
+	}
+}
@@ -0,0 +1,107 @@
+package mwclient
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy configures how Client retries requests that fail transiently
+// -- network errors, 429s, and 5xxs -- in addition to the maxlag retrying
+// configured through Client.Maxlag, which runs in its own middleware layer
+// whether or not a RetryPolicy is set. Assign a *RetryPolicy to
+// Client.RetryPolicy to opt in; leave it nil (the default) to disable this
+// kind of retrying.
+//
+// The API is modeled on hashicorp/go-retryablehttp's CheckRetry/Backoff
+// hooks so that callers can plug in their own retry logic.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of attempts after the first. A
+	// value of 0 means the request is tried once and not retried.
+	MaxRetries int
+	// MinDelay and MaxDelay bound the delay Backoff computes between
+	// attempts.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// CheckRetry decides whether an attempt should be retried, given the
+	// response (nil if the attempt failed before one was received) and/or
+	// the error from the attempt. Returning a non-nil error aborts
+	// retrying immediately and surfaces that error to the caller. Defaults
+	// to DefaultCheckRetry.
+	CheckRetry func(resp *http.Response, err error) (bool, error)
+	// Backoff computes how long to wait before the next attempt, given
+	// the zero-based attempt number that just failed and the response (if
+	// any) that triggered the retry. Defaults to DefaultBackoff.
+	Backoff func(min, max time.Duration, attempt int, resp *http.Response) time.Duration
+}
+
+// DefaultRetryPolicy returns a RetryPolicy using DefaultCheckRetry and
+// DefaultBackoff, retrying up to maxRetries times with delays bounded by
+// min and max.
+func DefaultRetryPolicy(maxRetries int, min, max time.Duration) *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries: maxRetries,
+		MinDelay:   min,
+		MaxDelay:   max,
+		CheckRetry: DefaultCheckRetry,
+		Backoff:    DefaultBackoff,
+	}
+}
+
+// DefaultCheckRetry reports true for network errors (err != nil) and for
+// 429 (Too Many Requests), 502 (Bad Gateway), 503 (Service Unavailable),
+// and 504 (Gateway Timeout) responses, which is what MediaWiki deployments
+// and the CDNs fronting them commonly return under load.
+//
+// ErrAPIBusy is the exception: it means maxlagMiddleware already retried
+// the request up to Maxlag.Retries times and gave up, so retrying it here
+// would just re-run that whole maxlag loop again rather than sharing its
+// budget, multiplying real HTTP requests. DefaultCheckRetry treats it as
+// terminal instead, so maxlag is the one layer that owns the retry budget
+// for a lagged server and ErrAPIBusy reaches the caller unwrapped.
+func DefaultCheckRetry(resp *http.Response, err error) (bool, error) {
+	if errors.Is(err, ErrAPIBusy) {
+		return false, nil
+	}
+	if err != nil {
+		return true, nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// DefaultBackoff honors a Retry-After response header if present and
+// parseable as a number of seconds, clamped to max. Otherwise it computes
+// an exponential delay with full jitter: a random duration in
+// [0, min(max, min*2^attempt)).
+func DefaultBackoff(min, max time.Duration, attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if ra := resp.Header.Get("Retry-After"); ra != "" {
+			if secs, err := strconv.Atoi(ra); err == nil {
+				d := time.Duration(secs) * time.Second
+				if d > max {
+					d = max
+				}
+				return d
+			}
+		}
+	}
+
+	ceiling := time.Duration(float64(min) * math.Pow(2, float64(attempt)))
+	if ceiling <= 0 || ceiling > max {
+		ceiling = max
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
@@ -0,0 +1,69 @@
+package mwclient
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestDefaultBackoffRetryAfter checks that a Retry-After header is honored
+// and clamped to max.
+func TestDefaultBackoffRetryAfter(t *testing.T) {
+	cases := []struct {
+		name       string
+		retryAfter string
+		max        time.Duration
+		want       time.Duration
+	}{
+		{"within max", "2", 10 * time.Second, 2 * time.Second},
+		{"clamped to max", "1000", 2 * time.Second, 2 * time.Second},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{"Retry-After": {c.retryAfter}}}
+			got := DefaultBackoff(time.Millisecond, c.max, 0, resp)
+			if got != c.want {
+				t.Errorf("DefaultBackoff = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// TestDefaultBackoffExponentialJitter checks that, absent a usable
+// Retry-After header, DefaultBackoff returns a value in
+// [0, min(max, min*2^attempt)), the full-jitter exponential window, and
+// that the window grows with attempt but never exceeds max.
+func TestDefaultBackoffExponentialJitter(t *testing.T) {
+	min := 10 * time.Millisecond
+	max := 1 * time.Second
+
+	for attempt := 0; attempt < 10; attempt++ {
+		ceiling := min * time.Duration(1<<uint(attempt))
+		if ceiling > max {
+			ceiling = max
+		}
+
+		for i := 0; i < 20; i++ {
+			got := DefaultBackoff(min, max, attempt, nil)
+			if got < 0 || got >= ceiling {
+				t.Fatalf("attempt %d: DefaultBackoff = %v, want in [0, %v)", attempt, got, ceiling)
+			}
+		}
+	}
+}
+
+// TestDefaultBackoffIgnoresUnparseableRetryAfter checks that a
+// non-numeric Retry-After header is ignored in favor of the exponential
+// jitter computation, rather than e.g. panicking or returning a zero
+// Duration unconditionally.
+func TestDefaultBackoffIgnoresUnparseableRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Retry-After": {"not-a-number"}}}
+	min := 10 * time.Millisecond
+	max := time.Second
+
+	got := DefaultBackoff(min, max, 0, resp)
+	if got < 0 || got >= min {
+		t.Errorf("DefaultBackoff = %v, want in [0, %v)", got, min)
+	}
+}
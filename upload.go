@@ -0,0 +1,163 @@
+package mwclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"cgt.name/pkg/go-mwclient/params"
+
+	"github.com/antonholmquist/jason"
+)
+
+// Upload performs an action=upload request, sending contents as the file
+// part named filename and p as the remaining form fields (e.g. "comment",
+// "text", "ignorewarnings"). p must not set "action"; Upload sets it to
+// "upload".
+// Upload will return any API errors and/or warnings (if no other errors
+// occur) as the error return value.
+func (w *Client) Upload(filename string, contents io.Reader, p params.Values) (*jason.Object, error) {
+	return w.UploadCtx(context.Background(), filename, contents, p)
+}
+
+// UploadCtx is like Upload, but it threads ctx through to the underlying
+// HTTP request so that it can be cancelled or given a deadline by the
+// caller.
+func (w *Client) UploadCtx(ctx context.Context, filename string, contents io.Reader, p params.Values) (*jason.Object, error) {
+	if p == nil {
+		p = params.Values{}
+	}
+	p.Set("action", "upload")
+
+	return w.parseJSON(w.callMultipart(ctx, p, filename, contents))
+}
+
+// UploadChunked uploads contents (of the given total size) to the stash in
+// chunks of at most chunkSize bytes using MediaWiki's chunked upload
+// protocol (stash/offset/filesize), then finalizes the upload as filename
+// with the remaining fields in p. Unlike Upload, it never needs to hold the
+// whole file in memory, making it suitable for large media files.
+// p must not set "action", "filename", "filekey", "offset", "filesize" or
+// "stash"; UploadChunked manages those itself.
+func (w *Client) UploadChunked(filename string, contents io.Reader, size int64, chunkSize int, p params.Values) (*jason.Object, error) {
+	return w.UploadChunkedCtx(context.Background(), filename, contents, size, chunkSize, p)
+}
+
+// UploadChunkedCtx is like UploadChunked, but it threads ctx through to the
+// underlying HTTP requests so that it can be cancelled or given a deadline
+// by the caller.
+func (w *Client) UploadChunkedCtx(ctx context.Context, filename string, contents io.Reader, size int64, chunkSize int, p params.Values) (*jason.Object, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("mwclient: chunkSize must be positive")
+	}
+
+	var filekey string
+	buf := make([]byte, chunkSize)
+	for offset := int64(0); offset < size; {
+		n, err := io.ReadFull(contents, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, fmt.Errorf("mwclient: unable to read chunk at offset %d: %v", offset, err)
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("mwclient: contents ended after %d bytes, expected size %d", offset, size)
+		}
+
+		chunkParams := params.Values{
+			"stash":    "1",
+			"filename": filename,
+			"offset":   strconv.FormatInt(offset, 10),
+			"filesize": strconv.FormatInt(size, 10),
+		}
+		if filekey != "" {
+			chunkParams["filekey"] = filekey
+		}
+
+		resp, err := w.UploadCtx(ctx, filename, bytes.NewReader(buf[:n]), chunkParams)
+		if err != nil {
+			return nil, fmt.Errorf("mwclient: chunk at offset %d failed: %v", offset, err)
+		}
+
+		filekey, err = resp.GetString("upload", "filekey")
+		if err != nil {
+			return nil, fmt.Errorf("mwclient: invalid API response: missing upload.filekey")
+		}
+
+		offset += int64(n)
+	}
+
+	if p == nil {
+		p = params.Values{}
+	}
+	p.Set("action", "upload")
+	p.Set("filename", filename)
+	p.Set("filekey", filekey)
+
+	return w.PostCtx(ctx, p)
+}
+
+// callMultipart is the multipart/form-data counterpart to doRequest: it
+// POSTs p as form fields alongside contents as a "file" part named
+// filename, applying the same UserAgent, Assert, and maxlag parameter
+// handling as doRequest. As with doRequest, retrying, rate limiting, and
+// debug dumping are handled by the middleware chain wrapped around
+// w.httpc's Transport rather than by callMultipart itself.
+func (w *Client) callMultipart(ctx context.Context, p params.Values, filename string, contents io.Reader) (io.ReadCloser, error) {
+	p.Set("format", "json")
+	p.Set("utf8", "")
+
+	if w.Maxlag.On {
+		if p.Get("maxlag") == "" {
+			p.Set("maxlag", w.Maxlag.Timeout)
+		}
+	}
+
+	if w.Assert > AssertNone {
+		switch w.Assert {
+		case AssertUser:
+			p.Set("assert", "user")
+		case AssertBot:
+			p.Set("assert", "bot")
+		}
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range p {
+		if err := mw.WriteField(k, v); err != nil {
+			return nil, fmt.Errorf("unable to write multipart field %q: %v", k, err)
+		}
+	}
+
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create multipart file part: %v", err)
+	}
+	if _, err := io.Copy(fw, contents); err != nil {
+		return nil, fmt.Errorf("unable to write file contents: %v", err)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("unable to finalize multipart body: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.apiURL.String(), &body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create HTTP request (method: POST, params: %v): %v", p, err)
+	}
+	req.Header.Set("User-Agent", w.UserAgent)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	// As with doRequest, w.httpc.Transport (the middleware chain built by
+	// Use/SetTransport) handles OAuth signing, debug dumping, rate
+	// limiting, and maxlag/RetryPolicy retrying, so a single Do call here
+	// may correspond to several HTTP round trips, each freshly signed.
+	resp, err := w.httpc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error occured during HTTP request: %w", err)
+	}
+
+	return resp.Body, nil
+}
@@ -0,0 +1,80 @@
+package mwclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// uploadStubServer returns a server that answers every request with a
+// successful action=upload response: a filekey for multipart chunk
+// requests, and a bare success for the x-www-form-urlencoded finalize
+// request. reqCount is incremented once per request received.
+func uploadStubServer(reqCount *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(wtr http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(reqCount, 1)
+		wtr.Header().Set("Content-Type", "application/json")
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			fmt.Fprint(wtr, `{"upload":{"result":"Continue","filekey":"abc123"}}`)
+			return
+		}
+		fmt.Fprint(wtr, `{"upload":{"result":"Success","filename":"f.txt"}}`)
+	}))
+}
+
+func TestUploadChunkedCtx(t *testing.T) {
+	var reqCount int32
+	srv := uploadStubServer(&reqCount)
+	defer srv.Close()
+
+	w, err := New(srv.URL, "test")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	contents := strings.Repeat("a", 20)
+	_, err = w.UploadChunkedCtx(context.Background(), "f.txt", strings.NewReader(contents), 20, 10, nil)
+	if err != nil {
+		t.Fatalf("UploadChunkedCtx: %v", err)
+	}
+
+	// Two 10-byte chunks plus one finalize request.
+	if got, want := atomic.LoadInt32(&reqCount), int32(3); got != want {
+		t.Fatalf("server received %d requests, want %d", got, want)
+	}
+}
+
+// TestUploadChunkedCtxShortContents is a regression test for the bug where
+// UploadChunkedCtx treated io.ReadFull returning (0, io.EOF) before offset
+// reached size as harmless and looped forever, firing an HTTP request with
+// an empty chunk on every iteration. contents yields far less than size
+// claims, so UploadChunkedCtx must return an error rather than hang.
+func TestUploadChunkedCtxShortContents(t *testing.T) {
+	var reqCount int32
+	srv := uploadStubServer(&reqCount)
+	defer srv.Close()
+
+	w, err := New(srv.URL, "test")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	_, err = w.UploadChunkedCtx(context.Background(), "f.txt", strings.NewReader("short"), 100, 10, nil)
+	if err == nil {
+		t.Fatal("UploadChunkedCtx: got nil error, want an error about contents ending early")
+	}
+	if !strings.Contains(err.Error(), "contents ended after 5 bytes, expected size 100") {
+		t.Fatalf("UploadChunkedCtx error = %q, want it to mention the short read", err)
+	}
+
+	// Only the one chunk built from the 5 bytes contents actually yielded
+	// should have reached the server; the bug made this request an
+	// unbounded stream of empty chunks instead.
+	if got, want := atomic.LoadInt32(&reqCount), int32(1); got != want {
+		t.Fatalf("server received %d requests, want %d", got, want)
+	}
+}